@@ -0,0 +1,83 @@
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sigstore/fulcio/pkg/config"
+)
+
+// configCmd is the parent of the `fulcio config` subcommands. It has no
+// behavior of its own.
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and validate Fulcio configuration documents",
+}
+
+var configProbe bool
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate <path>",
+	Short: "Parse and validate a Fulcio config file, reporting every error found",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		b, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("read %s: %w", args[0], err)
+		}
+
+		cfg, err := config.ParseAndValidate(b)
+		if err != nil {
+			return err
+		}
+
+		if !configProbe {
+			fmt.Printf("%s is valid\n", args[0])
+			return nil
+		}
+
+		// Use the already-parsed cfg directly, rather than config.Read,
+		// so a single unreachable issuer can't abort before ProbeIssuers
+		// gets a chance to collect every issuer's discovery error -- and
+		// so we don't run discovery twice or start background key-sync
+		// goroutines just to validate a config file.
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if errs := config.ProbeIssuers(ctx, cfg); len(errs) > 0 {
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			return fmt.Errorf("%d issuer(s) failed discovery", len(errs))
+		}
+
+		fmt.Printf("%s is valid, and all issuers passed discovery\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	configValidateCmd.Flags().BoolVar(&configProbe, "probe", false, "also perform live OIDC discovery against each configured IssuerURL")
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configWebhookCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sigstore/fulcio/pkg/config"
+	"github.com/sigstore/fulcio/pkg/log"
+)
+
+// configMapDataKey is the key inside the ConfigMap's Data map expected to
+// hold the Fulcio config document, matching pkg/config's Watcher.
+const configMapDataKey = "config.json"
+
+var (
+	webhookPort      int
+	webhookTLSCert   string
+	webhookTLSKey    string
+	webhookDataKey   string
+	webhookNamespace string
+	webhookName      string
+)
+
+var configWebhookCmd = &cobra.Command{
+	Use:   "webhook",
+	Short: "Serve a Kubernetes ValidatingAdmissionWebhook that rejects invalid Fulcio ConfigMaps",
+	Long: `Serve a Kubernetes ValidatingAdmissionWebhook that runs the same
+validator as 'fulcio config validate' and the running server against
+CREATE/UPDATE admission requests for ConfigMaps, so an operator error in a
+config never reaches a running Fulcio pod as a crash loop.
+
+Only the ConfigMap named by --namespace/--name is validated; every other
+ConfigMap admission this webhook is sent (the Kubernetes API server has no
+way to pre-filter by name) is allowed through untouched.`,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		if webhookNamespace == "" || webhookName == "" {
+			return fmt.Errorf("--namespace and --name are required, to scope validation to the Fulcio ConfigMap")
+		}
+		if webhookTLSCert == "" || webhookTLSKey == "" {
+			return fmt.Errorf("--tls-cert and --tls-key are required: the Kubernetes API server only calls admission webhooks over TLS")
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/validate", handleValidateConfigMap)
+
+		addr := fmt.Sprintf(":%d", webhookPort)
+		log.Logger.Infof("config webhook: listening on %s, scoped to configmap %s/%s", addr, webhookNamespace, webhookName)
+		return http.ListenAndServeTLS(addr, webhookTLSCert, webhookTLSKey, mux)
+	},
+}
+
+func init() {
+	configWebhookCmd.Flags().IntVar(&webhookPort, "port", 8443, "port to serve the admission webhook on")
+	configWebhookCmd.Flags().StringVar(&webhookTLSCert, "tls-cert", "", "path to a TLS certificate (required)")
+	configWebhookCmd.Flags().StringVar(&webhookTLSKey, "tls-key", "", "path to the TLS certificate's private key (required)")
+	configWebhookCmd.Flags().StringVar(&webhookDataKey, "data-key", configMapDataKey, "key within the ConfigMap's data holding the Fulcio config document")
+	configWebhookCmd.Flags().StringVar(&webhookNamespace, "namespace", "", "namespace of the ConfigMap to validate; other namespaces are allowed through (required)")
+	configWebhookCmd.Flags().StringVar(&webhookName, "name", "", "name of the ConfigMap to validate; other ConfigMaps are allowed through (required)")
+}
+
+// handleValidateConfigMap validates CREATE/UPDATE admission requests for
+// the single ConfigMap named by --namespace/--name. A ValidatingAdmissionWebhook
+// is typically registered against all "configmaps" in a namespace (there's
+// no server-side way to filter by name), so any ConfigMap that isn't the
+// one we're scoped to -- coredns, other operators' config, etc. -- must be
+// allowed through rather than rejected.
+func handleValidateConfigMap(w http.ResponseWriter, r *http.Request) {
+	review := &admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+		http.Error(w, fmt.Sprintf("decode admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "admission review missing request", http.StatusBadRequest)
+		return
+	}
+
+	response := &admissionv1.AdmissionResponse{
+		UID:     review.Request.UID,
+		Allowed: true,
+	}
+
+	cm := &corev1.ConfigMap{}
+	if op := review.Request.Operation; op != admissionv1.Create && op != admissionv1.Update {
+		// Only CREATE and UPDATE carry a ConfigMap to validate; allow the rest.
+	} else if err := json.Unmarshal(review.Request.Object.Raw, cm); err != nil {
+		response.Allowed = false
+		response.Result = &metav1.Status{Message: fmt.Sprintf("decode configmap: %v", err)}
+	} else if cm.Namespace != webhookNamespace || cm.Name != webhookName {
+		// Not the ConfigMap we validate; let it through unconditionally.
+	} else if raw, ok := cm.Data[webhookDataKey]; !ok {
+		response.Allowed = false
+		response.Result = &metav1.Status{Message: fmt.Sprintf("configmap missing key %q", webhookDataKey)}
+	} else if err := config.Validate([]byte(raw)); err != nil {
+		response.Allowed = false
+		response.Result = &metav1.Status{Message: err.Error()}
+	}
+
+	review.Response = response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		log.Logger.Warnf("config webhook: failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package app
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sigstore/fulcio/pkg/log"
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "fulcio",
+	Short: "Fulcio runs a CA for issuing code-signing certificates from an OIDC identity",
+}
+
+// Execute adds all child commands to the root command and runs it.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		log.Logger.Error(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/sigstore/fulcio/pkg/config"
+	"github.com/sigstore/fulcio/pkg/log"
+)
+
+var (
+	serveConfigPath    string
+	serveConfigMap     string
+	serveMetricsPort   int
+	serveAllowInsecure bool
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run the Fulcio server",
+	RunE: func(_ *cobra.Command, _ []string) error {
+		// Setting this here, rather than where it's read in
+		// validateConfig, makes the gate an explicit, auditable
+		// process-level opt-in: a config alone can never enable
+		// InsecureSkipVerify without this flag also being passed.
+		config.AllowInsecureOIDC = serveAllowInsecure
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		watcher, err := newConfigWatcher(ctx)
+		if err != nil {
+			return fmt.Errorf("start config watcher: %w", err)
+		}
+		defer watcher.Close()
+		ctx = config.WithProvider(ctx, watcher)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		metricsAddr := fmt.Sprintf(":%d", serveMetricsPort)
+		go func() {
+			log.Logger.Infof("serving metrics on %s", metricsAddr)
+			if err := http.ListenAndServe(metricsAddr, mux); err != nil { //nolint:gosec // metrics only, not internet-facing
+				log.Logger.Errorf("metrics server: %v", err)
+			}
+		}()
+
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		<-sigCh
+		log.Logger.Info("shutting down")
+		return nil
+	},
+}
+
+// newConfigWatcher starts a file-backed config.Watcher, or -- when
+// --configmap is set -- an in-cluster ConfigMap-backed one instead.
+func newConfigWatcher(ctx context.Context) (*config.Watcher, error) {
+	if serveConfigMap == "" {
+		return config.NewFileWatcher(ctx, serveConfigPath)
+	}
+
+	namespace, name, ok := strings.Cut(serveConfigMap, "/")
+	if !ok {
+		return nil, fmt.Errorf("--configmap must be of the form namespace/name, got %q", serveConfigMap)
+	}
+
+	restCfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("in-cluster config: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return nil, fmt.Errorf("build kubernetes client: %w", err)
+	}
+	return config.NewConfigMapWatcher(ctx, client, namespace, name)
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveConfigPath, "config-path", "/etc/fulcio-config/config.json", "path to the Fulcio config file; ignored when --configmap is set")
+	serveCmd.Flags().StringVar(&serveConfigMap, "configmap", "", "namespace/name of a Kubernetes ConfigMap to watch for the Fulcio config instead of a file, for in-cluster deployments")
+	serveCmd.Flags().IntVar(&serveMetricsPort, "metrics-port", 2112, "port to serve Prometheus metrics on")
+	serveCmd.Flags().BoolVar(&serveAllowInsecure, "allow-insecure-oidc", false, "allow issuers to set TLSConfig.InsecureSkipVerify; without this flag, any such issuer is rejected at config validation time")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package challenges
+
+import (
+	"fmt"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+
+	"github.com/sigstore/fulcio/pkg/config"
+)
+
+// PrincipalFromIDToken derives the certificate subject (and any optional
+// issuer override, group memberships, or extension values) for a verified
+// ID token, given the OIDCIssuer configuration that matched it.
+//
+// For IssuerTypeCustom issuers this resolves iss.ClaimMapping against the
+// token's claims, which is what lets a Keycloak, Auth0, Okta, or
+// self-hosted dex instance be onboarded purely through config.
+func PrincipalFromIDToken(iss config.OIDCIssuer, token *oidc.IDToken) (*config.Principal, error) {
+	if iss.Type != config.IssuerTypeCustom {
+		return nil, fmt.Errorf("issuer %s: type %s does not use claim-mapping subject resolution", iss.IssuerURL, iss.Type)
+	}
+
+	var claims map[string]interface{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("decode ID token claims: %w", err)
+	}
+
+	return config.ResolvePrincipal(iss, claims)
+}
@@ -0,0 +1,210 @@
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PaesslerAG/jsonpath"
+)
+
+// ClaimMapping lets an IssuerTypeCustom issuer map Fulcio's certificate
+// fields onto claims of the verified ID token using the same JSONPath
+// syntax as OIDCIssuer.IssuerClaim (e.g. "$.email" or
+// "$.federated_claims.connector_id"). This is what lets operators onboard
+// a Keycloak, Auth0, Okta, or self-hosted dex instance without Fulcio
+// needing bespoke subject-derivation logic for each provider.
+type ClaimMapping struct {
+	// Subject selects the claim used as the certificate subject. Required
+	// when the issuer's Type is IssuerTypeCustom.
+	Subject string `json:"Subject,omitempty"`
+	// Issuer optionally overrides which claim is treated as the token
+	// issuer, mirroring OIDCIssuer.IssuerClaim for the built-in types.
+	Issuer string `json:"Issuer,omitempty"`
+	// Groups optionally selects a claim containing the subject's group
+	// memberships, embedded as a certificate extension.
+	Groups string `json:"Groups,omitempty"`
+	// ExtensionOIDs maps an ASN.1 OID string (e.g. "1.3.6.1.4.1.57264.1.8")
+	// to a JSONPath expression selecting the claim to embed under that OID.
+	ExtensionOIDs map[string]string `json:"ExtensionOIDs,omitempty"`
+}
+
+// ResolveClaim evaluates a JSONPath expression against the verified ID
+// token claims, returning the matched value as a string. String claims are
+// returned as-is; any other matched value is JSON-marshaled.
+func ResolveClaim(expr string, claims map[string]interface{}) (string, error) {
+	v, err := jsonpath.Get(expr, claims)
+	if err != nil {
+		return "", fmt.Errorf("evaluate claim expression %q: %w", expr, err)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("marshal claim value for %q: %w", expr, err)
+	}
+	return string(b), nil
+}
+
+// ResolveGroups evaluates ClaimMapping.Groups against the verified ID
+// token claims, accepting either a single string claim or a list of
+// strings. It returns nil, nil if expr is empty.
+func ResolveGroups(expr string, claims map[string]interface{}) ([]string, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	v, err := jsonpath.Get(expr, claims)
+	if err != nil {
+		return nil, fmt.Errorf("evaluate groups expression %q: %w", expr, err)
+	}
+	switch g := v.(type) {
+	case string:
+		return []string{g}, nil
+	case []interface{}:
+		groups := make([]string, 0, len(g))
+		for _, entry := range g {
+			s, ok := entry.(string)
+			if !ok {
+				return nil, fmt.Errorf("groups expression %q: non-string group entry %v", expr, entry)
+			}
+			groups = append(groups, s)
+		}
+		return groups, nil
+	default:
+		return nil, fmt.Errorf("groups expression %q: unsupported claim type %T", expr, v)
+	}
+}
+
+// validateExpression parses a JSONPath expression, surfacing syntax errors
+// at config-validation time rather than at issuance time against a real
+// token. It does not evaluate the expression, since no claims exist yet.
+func validateExpression(expr string) error {
+	if _, err := jsonpath.New(expr); err != nil {
+		return fmt.Errorf("parse claim expression %q: %w", expr, err)
+	}
+	return nil
+}
+
+// parseExtensionOID validates that s is a well-formed dot-decimal ASN.1
+// object identifier, e.g. "1.3.6.1.4.1.57264.1.8".
+func parseExtensionOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("OID %q must have at least two arcs", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("OID %q: arc %q is not a non-negative integer", s, p)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// validateClaimMapping pre-compiles every JSONPath expression in mapping
+// and parses every ExtensionOIDs key, so a typo'd expression or OID is
+// caught at config-validation/lint time instead of at issuance time.
+func validateClaimMapping(mapping ClaimMapping) error {
+	if err := validateExpression(mapping.Subject); err != nil {
+		return err
+	}
+	if mapping.Issuer != "" {
+		if err := validateExpression(mapping.Issuer); err != nil {
+			return err
+		}
+	}
+	if mapping.Groups != "" {
+		if err := validateExpression(mapping.Groups); err != nil {
+			return err
+		}
+	}
+	for oid, expr := range mapping.ExtensionOIDs {
+		if _, err := parseExtensionOID(oid); err != nil {
+			return fmt.Errorf("ExtensionOIDs: %w", err)
+		}
+		if err := validateExpression(expr); err != nil {
+			return fmt.Errorf("ExtensionOIDs[%s]: %w", oid, err)
+		}
+	}
+	return nil
+}
+
+// Principal is the set of certificate fields derived from a verified ID
+// token for an IssuerTypeCustom issuer, resolved via its ClaimMapping.
+type Principal struct {
+	// Subject is the certificate subject, resolved from ClaimMapping.Subject.
+	Subject string
+	// Issuer overrides the token issuer used in the certificate, resolved
+	// from ClaimMapping.Issuer when set.
+	Issuer string
+	// Groups are the subject's group memberships, resolved from
+	// ClaimMapping.Groups when set.
+	Groups []string
+	// Extensions maps each ExtensionOIDs key to its resolved claim value.
+	Extensions map[string]string
+}
+
+// ResolvePrincipal resolves iss.ClaimMapping against the verified ID
+// token's claims, deriving the certificate subject and any optional
+// issuer/groups/extension values. iss.Type must be IssuerTypeCustom; this
+// is the function the challenge/issuance path calls once an incoming
+// token has matched a custom issuer.
+func ResolvePrincipal(iss OIDCIssuer, claims map[string]interface{}) (*Principal, error) {
+	if iss.Type != IssuerTypeCustom {
+		return nil, fmt.Errorf("ResolvePrincipal: issuer %s is not type %s", iss.IssuerURL, IssuerTypeCustom)
+	}
+
+	subject, err := ResolveClaim(iss.ClaimMapping.Subject, claims)
+	if err != nil {
+		return nil, fmt.Errorf("resolve subject: %w", err)
+	}
+
+	p := &Principal{Subject: subject}
+
+	if iss.ClaimMapping.Issuer != "" {
+		tokenIssuer, err := ResolveClaim(iss.ClaimMapping.Issuer, claims)
+		if err != nil {
+			return nil, fmt.Errorf("resolve issuer: %w", err)
+		}
+		p.Issuer = tokenIssuer
+	}
+
+	groups, err := ResolveGroups(iss.ClaimMapping.Groups, claims)
+	if err != nil {
+		return nil, fmt.Errorf("resolve groups: %w", err)
+	}
+	p.Groups = groups
+
+	if len(iss.ClaimMapping.ExtensionOIDs) > 0 {
+		p.Extensions = make(map[string]string, len(iss.ClaimMapping.ExtensionOIDs))
+		for oid, expr := range iss.ClaimMapping.ExtensionOIDs {
+			v, err := ResolveClaim(expr, claims)
+			if err != nil {
+				return nil, fmt.Errorf("resolve extension %s: %w", oid, err)
+			}
+			p.Extensions[oid] = v
+		}
+	}
+
+	return p, nil
+}
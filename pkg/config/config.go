@@ -17,32 +17,26 @@ package config
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"net"
-	"net/http"
 	"net/url"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/sigstore/fulcio/pkg/log"
 	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"golang.org/x/net/publicsuffix"
 )
 
 const defaultOIDCDiscoveryTimeout = 10 * time.Second
 
-// All hostnames for subject and issuer OIDC claims must have at least a
-// top-level and second-level domain
-const minimumHostnameLength = 2
-
 type FulcioConfig struct {
 	OIDCIssuers map[string]OIDCIssuer `json:"OIDCIssuers,omitempty"`
 
@@ -55,10 +49,25 @@ type FulcioConfig struct {
 	// * https://container.googleapis.com/v1/projects/mattmoor-credit/locations/us-west1-b/clusters/tenant-cluster
 	MetaIssuers map[string]OIDCIssuer `json:"MetaIssuers,omitempty"`
 
+	// AllowPrivateSuffixMatch controls how validateAllowedDomain handles
+	// hostnames under a suffix from the public suffix list's PRIVATE
+	// section (e.g. github.io, herokuapp.com). When set, such a suffix is
+	// used as the eTLD, so "docs.alice.github.io" and "alice.github.io"
+	// match while "alice.github.io" and "bob.github.io" do not. When unset,
+	// hostnames under a private suffix match only if they are identical.
+	AllowPrivateSuffixMatch bool `json:"AllowPrivateSuffixMatch,omitempty"`
+
+	// verifiersMu guards verifiers, which is swapped in place by the
+	// background key-sync goroutines started in prepare.
+	verifiersMu sync.RWMutex
 	// verifiers is a fixed mapping from our OIDCIssuers to their OIDC verifiers.
 	verifiers map[string]*oidc.IDTokenVerifier
 	// lru is an LRU cache of recently used verifiers for our meta issuers.
 	lru *lru.TwoQueueCache
+
+	// syncers holds the running background key-sync goroutines, one per
+	// configured issuer, so that Close can stop them.
+	syncers []*keySyncer
 }
 
 type OIDCIssuer struct {
@@ -78,6 +87,22 @@ type OIDCIssuer struct {
 	// issue ID tokens for. Tokens with a different trust domain will be
 	// rejected.
 	SPIFFETrustDomain string `json:"SPIFFETrustDomain,omitempty"`
+	// KeySyncInterval controls how often the background key-sync goroutine
+	// re-fetches this issuer's OIDC discovery document and JWKS. Defaults
+	// to defaultKeySyncInterval when unset.
+	KeySyncInterval time.Duration `json:"KeySyncInterval,omitempty"`
+	// KeySyncMinTTL is the minimum interval honored between refreshes, even
+	// if the JWKS response's Cache-Control/Expires headers ask for less.
+	// Defaults to defaultKeySyncMinTTL when unset.
+	KeySyncMinTTL time.Duration `json:"KeySyncMinTTL,omitempty"`
+	// TLSConfig controls how this issuer's discovery and JWKS endpoints are
+	// reached. Each issuer gets its own *http.Transport built from this
+	// configuration; issuers no longer share a global transport.
+	TLSConfig TLSConfig `json:"TLSConfig,omitempty"`
+	// ClaimMapping configures subject/issuer/groups derivation for
+	// IssuerTypeCustom issuers. Required when Type is IssuerTypeCustom,
+	// and must be unset otherwise.
+	ClaimMapping ClaimMapping `json:"ClaimMapping,omitempty"`
 }
 
 func metaRegex(issuer string) (*regexp.Regexp, error) {
@@ -118,6 +143,8 @@ func (fc *FulcioConfig) GetIssuer(issuerURL string) (OIDCIssuer, bool) {
 				Type:          iss.Type,
 				IssuerClaim:   iss.IssuerClaim,
 				SubjectDomain: iss.SubjectDomain,
+				TLSConfig:     iss.TLSConfig,
+				ClaimMapping:  iss.ClaimMapping,
 			}, true
 		}
 	}
@@ -129,8 +156,11 @@ func (fc *FulcioConfig) GetIssuer(issuerURL string) (OIDCIssuer, bool) {
 // coming from an incoming OIDC token.  If no matching configuration
 // is found, then it returns `false`.
 func (fc *FulcioConfig) GetVerifier(issuerURL string) (*oidc.IDTokenVerifier, bool) {
-	// Look up our fixed issuer verifiers
+	// Look up our fixed issuer verifiers. These may be swapped in place by
+	// the background key-sync goroutines started in prepare, hence the lock.
+	fc.verifiersMu.RLock()
 	v, ok := fc.verifiers[issuerURL]
+	fc.verifiersMu.RUnlock()
 	if ok {
 		return v, true
 	}
@@ -148,9 +178,15 @@ func (fc *FulcioConfig) GetVerifier(issuerURL string) (*oidc.IDTokenVerifier, bo
 		return nil, false
 	}
 
+	client, err := buildIssuerClient(iss)
+	if err != nil {
+		log.Logger.Warnf("Failed to build client for issuer URL %q: %v", issuerURL, err)
+		return nil, false
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), defaultOIDCDiscoveryTimeout)
 	defer cancel()
-	provider, err := oidc.NewProvider(ctx, issuerURL)
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, client), issuerURL)
 	if err != nil {
 		log.Logger.Warnf("Failed to create provider for issuer URL %q: %v", issuerURL, err)
 		return nil, false
@@ -166,24 +202,9 @@ func (fc *FulcioConfig) prepare() error {
 		ctx, cancel := context.WithTimeout(context.Background(), defaultOIDCDiscoveryTimeout)
 		defer cancel()
 
-		dialer := &net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}
-		transport := &http.Transport{
-			Proxy:                 http.ProxyFromEnvironment,
-			DialContext:           dialer.DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
-		}
-
-		var client *http.Client
-		client = &http.Client{
-			Transport: transport,
+		client, err := buildIssuerClient(iss)
+		if err != nil {
+			return fmt.Errorf("issuer %s: %w", iss.IssuerURL, err)
 		}
 		clientctx := oidc.ClientContext(ctx, client)
 
@@ -192,6 +213,10 @@ func (fc *FulcioConfig) prepare() error {
 			return fmt.Errorf("provider %s: %w", iss.IssuerURL, err)
 		}
 		fc.verifiers[iss.IssuerURL] = provider.Verifier(&oidc.Config{ClientID: iss.ClientID})
+
+		syncer := newKeySyncer(fc, iss, client)
+		syncer.start(context.Background())
+		fc.syncers = append(fc.syncers, syncer)
 	}
 
 	cache, err := lru.New2Q(100 /* size */)
@@ -202,6 +227,16 @@ func (fc *FulcioConfig) prepare() error {
 	return nil
 }
 
+// Close stops the background key-sync goroutines started by prepare. It is
+// safe to call on a config that was never prepared, and safe to call more
+// than once.
+func (fc *FulcioConfig) Close() {
+	for _, s := range fc.syncers {
+		s.stop()
+	}
+	fc.syncers = nil
+}
+
 type IssuerType string
 
 const (
@@ -211,6 +246,12 @@ const (
 	IssuerTypeSpiffe         = "spiffe"
 	IssuerTypeURI            = "uri"
 	IssuerTypeUsername       = "username"
+	// IssuerTypeCustom derives the certificate subject (and optionally
+	// issuer/groups/extension values) from OIDCIssuer.ClaimMapping instead
+	// of built-in, provider-specific logic. Use this to onboard a
+	// Keycloak, Auth0, Okta, or self-hosted dex instance without patching
+	// Fulcio for each new provider.
+	IssuerTypeCustom = "custom"
 )
 
 func parseConfig(b []byte) (cfg *FulcioConfig, err error) {
@@ -231,6 +272,20 @@ func validateConfig(conf *FulcioConfig) error {
 		if issuer.IssuerClaim != "" && issuer.Type != IssuerTypeEmail {
 			return errors.New("only email issuers can use issuer claim mapping")
 		}
+		if issuer.TLSConfig.InsecureSkipVerify && !AllowInsecureOIDC {
+			return fmt.Errorf("issuer %s: TLSConfig.InsecureSkipVerify requires the --allow-insecure-oidc server flag", issuer.IssuerURL)
+		}
+		if issuer.Type == IssuerTypeCustom {
+			if issuer.ClaimMapping.Subject == "" {
+				return fmt.Errorf("issuer %s: custom issuer must have ClaimMapping.Subject set", issuer.IssuerURL)
+			}
+			if err := validateClaimMapping(issuer.ClaimMapping); err != nil {
+				return fmt.Errorf("issuer %s: %w", issuer.IssuerURL, err)
+			}
+		} else if issuer.ClaimMapping.Subject != "" || issuer.ClaimMapping.Issuer != "" ||
+			issuer.ClaimMapping.Groups != "" || len(issuer.ClaimMapping.ExtensionOIDs) > 0 {
+			return fmt.Errorf("issuer %s: only custom issuers can use ClaimMapping", issuer.IssuerURL)
+		}
 		if issuer.Type == IssuerTypeSpiffe {
 			if issuer.SPIFFETrustDomain == "" {
 				return errors.New("spiffe issuer must have SPIFFETrustDomain set")
@@ -265,7 +320,7 @@ func validateConfig(conf *FulcioConfig) error {
 			// * SubjectDomain = https://example.com, IssuerURL = https://accounts.example.com
 			// * SubjectDomain = https://accounts.example.com, IssuerURL = https://accounts.example.com
 			// * SubjectDomain = https://users.example.com, IssuerURL = https://accounts.example.com
-			if err := isURISubjectAllowed(uDomain, uIssuer); err != nil {
+			if err := isURISubjectAllowed(uDomain, uIssuer, conf.AllowPrivateSuffixMatch); err != nil {
 				return err
 			}
 		}
@@ -294,18 +349,21 @@ func validateConfig(conf *FulcioConfig) error {
 			// * SubjectDomain = example.com, IssuerURL = https://accounts.example.com
 			// * SubjectDomain = accounts.example.com, IssuerURL = https://accounts.example.com
 			// * SubjectDomain = users.example.com, IssuerURL = https://accounts.example.com
-			if err := validateAllowedDomain(issuer.SubjectDomain, uIssuer.Hostname()); err != nil {
+			if err := validateAllowedDomain(issuer.SubjectDomain, uIssuer.Hostname(), conf.AllowPrivateSuffixMatch); err != nil {
 				return err
 			}
 		}
 	}
 
-	for _, metaIssuer := range conf.MetaIssuers {
+	for pattern, metaIssuer := range conf.MetaIssuers {
 		if metaIssuer.Type == IssuerTypeSpiffe {
 			// This would establish a many to one relationship for OIDC issuers
 			// to trust domains so we fail early and reject this configuration.
 			return errors.New("SPIFFE meta issuers not supported")
 		}
+		if metaIssuer.TLSConfig.InsecureSkipVerify && !AllowInsecureOIDC {
+			return fmt.Errorf("meta issuer %s: TLSConfig.InsecureSkipVerify requires the --allow-insecure-oidc server flag", pattern)
+		}
 	}
 
 	return nil
@@ -332,21 +390,31 @@ var DefaultConfig = &FulcioConfig{
 	},
 }
 
-var originalTransport = http.DefaultTransport
-
 type configKey struct{}
 
+// With stashes cfg in ctx as a fixed, never-changing ConfigProvider. Use
+// WithProvider instead when the config should hot-reload, e.g. from a
+// Watcher.
 func With(ctx context.Context, cfg *FulcioConfig) context.Context {
-	ctx = context.WithValue(ctx, configKey{}, cfg)
-	return ctx
+	return WithProvider(ctx, NewStaticProvider(cfg))
 }
 
+// WithProvider stashes a ConfigProvider in ctx. FromContext obtains the
+// current configuration snapshot from it on every call, so callers that
+// hold a long-lived ctx (e.g. across a request) still observe config
+// updates made by a Watcher.
+func WithProvider(ctx context.Context, provider ConfigProvider) context.Context {
+	return context.WithValue(ctx, configKey{}, provider)
+}
+
+// FromContext returns the current configuration snapshot, as obtained from
+// the ConfigProvider stored in ctx by With or WithProvider.
 func FromContext(ctx context.Context) *FulcioConfig {
 	untyped := ctx.Value(configKey{})
 	if untyped == nil {
 		return nil
 	}
-	return untyped.(*FulcioConfig)
+	return untyped.(ConfigProvider).Config()
 }
 
 // Load a config from disk, or use defaults
@@ -366,25 +434,6 @@ func Load(configPath string) (*FulcioConfig, error) {
 	return Read(b)
 }
 
-var caCert = `-----BEGIN CERTIFICATE-----
-MIIC2jCCAcKgAwIBAgIRAO8GakGeX5OJDWFdieQ8QW8wDQYJKoZIhvcNAQELBQAw
-ADAeFw0yMjAzMTcxNzQ4MTJaFw0yMjA2MTUxNzQ4MTJaMAAwggEiMA0GCSqGSIb3
-DQEBAQUAA4IBDwAwggEKAoIBAQDHQRLeXWvFTxtsaMTaYK380BcYHbJWZTVdTYQz
-v36UpnxQb2L64P4zJO5I/8NwLjGKsT5HYWjV5nrgAKKiPDmgqVtk/krxANTyJ+KX
-eswx7gC+58vjAsSILOnxDN5Je1jDx4FSd49VoHZ1+04jkCLgotcixMBf5yYBAxA2
-So1CQGP4Qzla4VExoykCaos4rfxltUMHRQ5P0GYP1Ey3lqFka1LIJTTBoRgiRdJ0
-2DtzlR/NYVRP1xkLKe2VHJD+bkqXFx+Fti9QC/xod5eEN5wlF3TtIfSFFoSmbKOr
-TfIlt8wX2CT/6bmY8LEYLTsqoVLA14BwRr5CQxGuUlrATENnAgMBAAGjTzBNMA4G
-A1UdDwEB/wQEAwIFoDAMBgNVHRMBAf8EAjAAMC0GA1UdEQEB/wQjMCGCH215LXN1
-cGVydmlzb3IuZGVtby5waW5uaXBlZC5kZXYwDQYJKoZIhvcNAQELBQADggEBAE92
-wWZJ01qTkkcm+Onh1zeHss+PBHyff9TpnQQFFGnUPzmzfOOKUR5H2kY2+d/avM+V
-Mva2RU0qlJyxw+6k5hV1bcrQNhCXhCh+c/BBnkxm/dY/zqPMuHas1Qh3Eh1qImFs
-ls0PQhK4MijOavd4xeEKR1fP7UnrbNc6X8GePV+YOa1WO3ULTpY86as/Rd+iMCKQ
-f3Ztd93BXUo7xJ7hSzsWX82pS32Vm6IqE2DiZ0VPDdobABzHTLumY+aI1A20A9Pw
-tUrh0wbVHREvz0YVk98589DvgXBIiKCuv6g2zwgXTetKu73J0VBm3rrQdSKxAVri
-6E7Htz6UlXZbGWN3v4Y=
------END CERTIFICATE-----`
-
 // Read parses the bytes of a config
 func Read(b []byte) (*FulcioConfig, error) {
 	config, err := parseConfig(b)
@@ -397,37 +446,6 @@ func Read(b []byte) (*FulcioConfig, error) {
 		return nil, fmt.Errorf("validate: %w", err)
 	}
 
-	if _, ok := config.GetIssuer("https://kubernetes.default.svc"); ok {
-		// Add the Kubernetes cluster's CA to the system CA pool, and to
-		// the default transport.
-		rootCAs, _ := x509.SystemCertPool()
-		if rootCAs == nil {
-			rootCAs = x509.NewCertPool()
-		}
-		const k8sCA = "/var/run/fulcio/ca.crt"
-		certs, err := ioutil.ReadFile(k8sCA)
-		if err != nil {
-			return nil, fmt.Errorf("read file: %w", err)
-		}
-		if ok := rootCAs.AppendCertsFromPEM(certs); !ok {
-			return nil, fmt.Errorf("unable to append certs")
-		}
-
-		t := originalTransport.(*http.Transport).Clone()
-		t.TLSClientConfig.RootCAs = rootCAs
-		http.DefaultTransport = t
-	} else {
-		// If we parse a config that doesn't include a cluster issuer
-		// signed with the cluster'sCA, then restore the original transport
-		// (in case we overwrote it)
-
-		log.Logger.Infof("using the pre-defined CA: %v", caCert)
-		t := originalTransport.(*http.Transport).Clone()
-		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		http.DefaultTransport = t
-		//http.DefaultTransport = originalTransport
-	}
-
 	if err := config.prepare(); err != nil {
 		return nil, err
 	}
@@ -436,36 +454,67 @@ func Read(b []byte) (*FulcioConfig, error) {
 
 // isURISubjectAllowed compares the subject and issuer URIs,
 // returning an error if the scheme or the hostnames do not match
-func isURISubjectAllowed(subject, issuer *url.URL) error {
+func isURISubjectAllowed(subject, issuer *url.URL, allowPrivateSuffixMatch bool) error {
 	if subject.Scheme != issuer.Scheme {
 		return fmt.Errorf("subject (%s) and issuer (%s) URI schemes do not match", subject.Scheme, issuer.Scheme)
 	}
 
-	return validateAllowedDomain(subject.Hostname(), issuer.Hostname())
+	return validateAllowedDomain(subject.Hostname(), issuer.Hostname(), allowPrivateSuffixMatch)
 }
 
-// validateAllowedDomain compares two hostnames, returning an error if the
-// top-level and second-level domains do not match
-// TODO: This does not work for domains that end in co.jp or co.uk. We should consider
-// using eTLDs, or removing this validation when we can challenge domain ownership.
-func validateAllowedDomain(subjectHostname, issuerHostname string) error {
+// validateAllowedDomain compares two hostnames, returning an error unless
+// they share the same effective TLD+1 (eTLD+1), e.g. "example.com" or
+// "example.co.uk". This uses the public suffix list rather than a simple
+// two-label comparison, since the latter gets domains like co.uk, co.jp,
+// com.au, and GitHub Pages' github.io wrong: it would treat "co.uk" itself
+// as the registrable suffix, falsely matching any two distinct ".co.uk"
+// registrants. A trailing dot is trimmed from both hostnames before
+// comparison; punycoded (xn--) hostnames are compared in the ASCII form
+// given, with no IDNA conversion. allowPrivateSuffixMatch controls whether
+// suffixes on the public suffix list's PRIVATE section (github.io,
+// herokuapp.com, etc.) are used as the eTLD; see
+// FulcioConfig.AllowPrivateSuffixMatch.
+func validateAllowedDomain(subjectHostname, issuerHostname string, allowPrivateSuffixMatch bool) error {
+	subjectHostname = strings.TrimSuffix(subjectHostname, ".")
+	issuerHostname = strings.TrimSuffix(issuerHostname, ".")
+
 	// If the hostnames exactly match, return early
 	if subjectHostname == issuerHostname {
 		return nil
 	}
 
-	// Compare the top level and second level domains
-	sHostname := strings.Split(subjectHostname, ".")
-	iHostname := strings.Split(issuerHostname, ".")
-	if len(sHostname) < minimumHostnameLength {
-		return fmt.Errorf("URI hostname too short: %s", subjectHostname)
+	subjectDomain, err := effectiveTLDPlusOne(subjectHostname, allowPrivateSuffixMatch)
+	if err != nil {
+		return fmt.Errorf("subject hostname %s: %w", subjectHostname, err)
 	}
-	if len(iHostname) < minimumHostnameLength {
-		return fmt.Errorf("URI hostname too short: %s", issuerHostname)
+	issuerDomain, err := effectiveTLDPlusOne(issuerHostname, allowPrivateSuffixMatch)
+	if err != nil {
+		return fmt.Errorf("issuer hostname %s: %w", issuerHostname, err)
 	}
-	if sHostname[len(sHostname)-1] == iHostname[len(iHostname)-1] &&
-		sHostname[len(sHostname)-2] == iHostname[len(iHostname)-2] {
+
+	if subjectDomain == issuerDomain {
 		return nil
 	}
-	return fmt.Errorf("hostname top-level and second-level domains do not match: %s, %s", subjectHostname, issuerHostname)
+	return fmt.Errorf("hostname eTLD+1 domains do not match: %s, %s", subjectHostname, issuerHostname)
+}
+
+// effectiveTLDPlusOne computes the eTLD+1 (e.g. "example.co.uk") for
+// hostname. Unless allowPrivateSuffixMatch is set, it returns an error for
+// a hostname whose public suffix is not an ICANN one (a PRIVATE-section
+// suffix, or one not on the list at all).
+func effectiveTLDPlusOne(hostname string, allowPrivateSuffixMatch bool) (string, error) {
+	if allowPrivateSuffixMatch {
+		return publicsuffix.EffectiveTLDPlusOne(hostname)
+	}
+
+	suffix, icann := publicsuffix.PublicSuffix(strings.ToLower(hostname))
+	if !icann && !strings.HasSuffix(strings.ToLower(hostname), suffix) {
+		// publicsuffix.PublicSuffix never fails to return a best-effort
+		// suffix, but guard anyway before calling EffectiveTLDPlusOne.
+		return "", fmt.Errorf("no ICANN public suffix found")
+	}
+	if !icann {
+		return "", fmt.Errorf("hostname is on a private (non-ICANN) public suffix; set AllowPrivateSuffixMatch to allow it")
+	}
+	return publicsuffix.EffectiveTLDPlusOne(hostname)
 }
@@ -0,0 +1,120 @@
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import "testing"
+
+func TestValidateAllowedDomain(t *testing.T) {
+	tests := []struct {
+		name                    string
+		subjectHostname         string
+		issuerHostname          string
+		allowPrivateSuffixMatch bool
+		wantErr                 bool
+	}{
+		{
+			name:            "exact match",
+			subjectHostname: "accounts.example.com",
+			issuerHostname:  "accounts.example.com",
+			wantErr:         false,
+		},
+		{
+			name:            "same eTLD+1, different subdomains",
+			subjectHostname: "example.com",
+			issuerHostname:  "accounts.example.com",
+			wantErr:         false,
+		},
+		{
+			name:            "different eTLD+1 under .com",
+			subjectHostname: "example.com",
+			issuerHostname:  "evil.com",
+			wantErr:         true,
+		},
+		{
+			name:            "co.uk is a multi-label ICANN suffix, same registrant",
+			subjectHostname: "example.co.uk",
+			issuerHostname:  "accounts.example.co.uk",
+			wantErr:         false,
+		},
+		{
+			name:            "co.uk is a multi-label ICANN suffix, different registrants",
+			subjectHostname: "example.co.uk",
+			issuerHostname:  "other.co.uk",
+			wantErr:         true,
+		},
+		{
+			name:            "co.jp is a multi-label ICANN suffix, same registrant",
+			subjectHostname: "example.co.jp",
+			issuerHostname:  "sso.example.co.jp",
+			wantErr:         false,
+		},
+		{
+			name:            "com.au is a multi-label ICANN suffix, different registrants",
+			subjectHostname: "example.com.au",
+			issuerHostname:  "other.com.au",
+			wantErr:         true,
+		},
+		{
+			name:            "github.io private suffix rejected by default",
+			subjectHostname: "alice.github.io",
+			issuerHostname:  "bob.github.io",
+			wantErr:         true,
+		},
+		{
+			name:                    "github.io private suffix allowed with AllowPrivateSuffixMatch, same registrant",
+			subjectHostname:         "docs.alice.github.io",
+			issuerHostname:          "alice.github.io",
+			allowPrivateSuffixMatch: true,
+			wantErr:                 false,
+		},
+		{
+			name:                    "github.io private suffix, different registrants still rejected even with AllowPrivateSuffixMatch",
+			subjectHostname:         "alice.github.io",
+			issuerHostname:          "bob.github.io",
+			allowPrivateSuffixMatch: true,
+			wantErr:                 true,
+		},
+		{
+			// xn--p1ai is the punycode encoding of Russia's IDN ccTLD (.рф).
+			name:            "punycoded IDN hostnames, same registrant",
+			subjectHostname: "example.xn--p1ai",
+			issuerHostname:  "accounts.example.xn--p1ai",
+			wantErr:         false,
+		},
+		{
+			name:            "trailing dot is normalized before comparison",
+			subjectHostname: "example.com.",
+			issuerHostname:  "accounts.example.com",
+			wantErr:         false,
+		},
+		{
+			name:            "trailing dot on both sides, mismatched domains",
+			subjectHostname: "example.com.",
+			issuerHostname:  "evil.com.",
+			wantErr:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAllowedDomain(tt.subjectHostname, tt.issuerHostname, tt.allowPrivateSuffixMatch)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAllowedDomain(%q, %q, %v) = %v, wantErr %v",
+					tt.subjectHostname, tt.issuerHostname, tt.allowPrivateSuffixMatch, err, tt.wantErr)
+			}
+		})
+	}
+}
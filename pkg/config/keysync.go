@@ -0,0 +1,227 @@
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/sigstore/fulcio/pkg/log"
+)
+
+const (
+	// defaultKeySyncInterval is how often a keySyncer re-fetches an
+	// issuer's OIDC discovery document and JWKS when the issuer doesn't
+	// set KeySyncInterval explicitly.
+	defaultKeySyncInterval = 6 * time.Hour
+	// defaultKeySyncMinTTL is the floor applied to whatever interval a
+	// JWKS response's Cache-Control/Expires headers ask for, so a
+	// misbehaving IdP can't make us hammer its discovery endpoint.
+	defaultKeySyncMinTTL = 5 * time.Minute
+	// maxKeySyncBackoff caps the exponential backoff applied after
+	// consecutive refresh failures.
+	maxKeySyncBackoff = 30 * time.Minute
+	// keySyncJitterFraction is the maximum fraction of the sync interval
+	// added as random jitter, to avoid every issuer's goroutine waking
+	// the same IdP at the same time.
+	keySyncJitterFraction = 0.2
+)
+
+// keySyncer periodically refreshes the OIDC discovery document and JWKS for
+// a single issuer, atomically swapping the *oidc.IDTokenVerifier that
+// FulcioConfig.GetVerifier hands back to callers.
+type keySyncer struct {
+	issuerURL string
+	clientID  string
+	interval  time.Duration
+	minTTL    time.Duration
+	client    *http.Client
+
+	fc *FulcioConfig
+
+	// lastSuccessUnixNano holds the UnixNano timestamp of the last
+	// successful refresh, or 0 if none has happened yet. It's read by
+	// keySetAgeCollector at scrape time to compute key-set age.
+	lastSuccessUnixNano int64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// lastSuccessAge returns how long ago the last successful refresh
+// happened, and false if no refresh has ever succeeded.
+func (k *keySyncer) lastSuccessAge() (time.Duration, bool) {
+	nano := atomic.LoadInt64(&k.lastSuccessUnixNano)
+	if nano == 0 {
+		return 0, false
+	}
+	return time.Since(time.Unix(0, nano)), true
+}
+
+// newKeySyncer constructs a keySyncer for iss, falling back to package
+// defaults for any interval/TTL left unset in the issuer config.
+func newKeySyncer(fc *FulcioConfig, iss OIDCIssuer, client *http.Client) *keySyncer {
+	interval := iss.KeySyncInterval
+	if interval <= 0 {
+		interval = defaultKeySyncInterval
+	}
+	minTTL := iss.KeySyncMinTTL
+	if minTTL <= 0 {
+		minTTL = defaultKeySyncMinTTL
+	}
+	return &keySyncer{
+		issuerURL: iss.IssuerURL,
+		clientID:  iss.ClientID,
+		interval:  interval,
+		minTTL:    minTTL,
+		client:    client,
+		fc:        fc,
+		done:      make(chan struct{}),
+	}
+}
+
+// start launches the background refresh loop. It returns immediately; the
+// loop runs until ctx is canceled or stop is called.
+func (k *keySyncer) start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	k.cancel = cancel
+	keySetAge.register(k)
+
+	go func() {
+		defer close(k.done)
+		backoff := time.Duration(0)
+		wait := k.jitter(k.interval)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+
+			next, err := k.refresh(ctx)
+			if err != nil {
+				log.Logger.Warnf("key sync: failed to refresh issuer %q: %v", k.issuerURL, err)
+				keySyncFailureTotal.WithLabelValues(k.issuerURL).Inc()
+				if backoff == 0 {
+					backoff = time.Minute
+				} else {
+					backoff *= 2
+				}
+				if backoff > maxKeySyncBackoff {
+					backoff = maxKeySyncBackoff
+				}
+				wait = k.jitter(backoff)
+				continue
+			}
+
+			backoff = 0
+			keySyncSuccessTotal.WithLabelValues(k.issuerURL).Inc()
+			atomic.StoreInt64(&k.lastSuccessUnixNano, time.Now().UnixNano())
+			wait = k.jitter(next)
+		}
+	}()
+}
+
+// stop cancels the refresh loop and waits for it to exit.
+func (k *keySyncer) stop() {
+	if k.cancel != nil {
+		k.cancel()
+	}
+	<-k.done
+	keySetAge.unregister(k)
+}
+
+// jitter adds up to keySyncJitterFraction of random jitter to d so that
+// many issuers' goroutines don't all wake in lockstep.
+func (k *keySyncer) jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	max := float64(d) * keySyncJitterFraction
+	return d + time.Duration(rand.Float64()*max) //nolint:gosec
+}
+
+// refresh re-runs OIDC discovery for the issuer, rebuilds its verifier, and
+// swaps it into fc.verifiers under fc.verifiersMu. It returns the interval
+// to wait before the next refresh, honoring the JWKS response's
+// Cache-Control/Expires headers (bounded below by k.minTTL).
+func (k *keySyncer) refresh(ctx context.Context) (time.Duration, error) {
+	clientctx := oidc.ClientContext(ctx, k.client)
+	provider, err := oidc.NewProvider(clientctx, k.issuerURL)
+	if err != nil {
+		return 0, err
+	}
+	verifier := provider.Verifier(&oidc.Config{ClientID: k.clientID})
+
+	k.fc.verifiersMu.Lock()
+	k.fc.verifiers[k.issuerURL] = verifier
+	k.fc.verifiersMu.Unlock()
+
+	ttl := k.jwksTTL(ctx, provider)
+	if ttl < k.minTTL {
+		ttl = k.minTTL
+	}
+	return ttl, nil
+}
+
+// jwksTTL fetches the issuer's JWKS document and derives how long its keys
+// should be considered fresh from the response's Cache-Control max-age or
+// Expires header. It falls back to k.interval if neither is present or the
+// JWKS URI can't be determined.
+func (k *keySyncer) jwksTTL(ctx context.Context, provider *oidc.Provider) time.Duration {
+	var claims struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := provider.Claims(&claims); err != nil || claims.JWKSURI == "" {
+		return k.interval
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, claims.JWKSURI, nil)
+	if err != nil {
+		return k.interval
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return k.interval
+	}
+	defer resp.Body.Close()
+
+	if cc := resp.Header.Get("Cache-Control"); cc != "" {
+		for _, directive := range strings.Split(cc, ",") {
+			directive = strings.TrimSpace(directive)
+			if strings.HasPrefix(directive, "max-age=") {
+				rest := strings.TrimPrefix(directive, "max-age=")
+				if secs, err := strconv.Atoi(rest); err == nil {
+					return time.Duration(secs) * time.Second
+				}
+			}
+		}
+	}
+	if exp := resp.Header.Get("Expires"); exp != "" {
+		if t, err := http.ParseTime(exp); err == nil {
+			if d := time.Until(t); d > 0 {
+				return d
+			}
+		}
+	}
+	return k.interval
+}
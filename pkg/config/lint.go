@@ -0,0 +1,74 @@
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// Validate parses and validates a config document without preparing OIDC
+// providers or starting any background goroutines. It is what the ConfigMap
+// admission webhook runs; `fulcio config validate` runs ParseAndValidate,
+// and Read runs parseConfig and validateConfig directly, so all three apply
+// the same checks. The TLSConfig.InsecureSkipVerify check reads
+// AllowInsecureOIDC, which only `fulcio serve --allow-insecure-oidc` sets:
+// `fulcio config validate` and the webhook always reject such issuers.
+func Validate(b []byte) error {
+	_, err := ParseAndValidate(b)
+	return err
+}
+
+// ParseAndValidate parses and validates a config document, like Validate,
+// but also returns the parsed *FulcioConfig for callers that need to
+// inspect it further (e.g. ProbeIssuers) without paying for prepare's live
+// discovery round and background key-sync goroutines.
+func ParseAndValidate(b []byte) (*FulcioConfig, error) {
+	cfg, err := parseConfig(b)
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+	return cfg, nil
+}
+
+// ProbeIssuers performs live OIDC discovery against every concrete
+// OIDCIssuer in cfg (meta issuers are templated and can't be probed
+// directly) and returns one error per issuer that failed, rather than
+// stopping at the first failure. This backs `fulcio config validate
+// --probe`.
+func ProbeIssuers(ctx context.Context, cfg *FulcioConfig) []error {
+	var errs []error
+	for _, iss := range cfg.OIDCIssuers {
+		client, err := buildIssuerClient(iss)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("issuer %s: %w", iss.IssuerURL, err))
+			continue
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, defaultOIDCDiscoveryTimeout)
+		_, err = oidc.NewProvider(oidc.ClientContext(probeCtx, client), iss.IssuerURL)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("issuer %s: discovery failed: %w", iss.IssuerURL, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	keySyncSuccessTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fulcio_oidc_key_sync_success_total",
+		Help: "Number of successful OIDC discovery/JWKS refreshes, by issuer URL.",
+	}, []string{"issuer"})
+
+	keySyncFailureTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fulcio_oidc_key_sync_failure_total",
+		Help: "Number of failed OIDC discovery/JWKS refreshes, by issuer URL.",
+	}, []string{"issuer"})
+
+	configReloadSuccessTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fulcio_config_reload_success_total",
+		Help: "Number of times a watched FulcioConfig source was successfully reloaded.",
+	})
+
+	configReloadFailureTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fulcio_config_reload_failure_total",
+		Help: "Number of failed reloads of a watched FulcioConfig source (read, parse, validate or OIDC discovery error) and of file-watch errors; the previous config stayed live.",
+	})
+)
+
+var keySetAgeDesc = prometheus.NewDesc(
+	"fulcio_oidc_key_set_age_seconds",
+	"Age in seconds of the currently cached verifier for an issuer, since its last successful refresh.",
+	[]string{"issuer"}, nil,
+)
+
+// keySetAgeCollector reports the age of each active keySyncer's verifier
+// at scrape time, computed from the syncer's last success timestamp,
+// rather than being set once and going stale between refreshes.
+type keySetAgeCollector struct {
+	mu      sync.Mutex
+	syncers map[*keySyncer]struct{}
+}
+
+var keySetAge = &keySetAgeCollector{syncers: map[*keySyncer]struct{}{}}
+
+func init() {
+	prometheus.MustRegister(keySetAge)
+}
+
+func (c *keySetAgeCollector) register(k *keySyncer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.syncers[k] = struct{}{}
+}
+
+func (c *keySetAgeCollector) unregister(k *keySyncer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.syncers, k)
+}
+
+// Describe implements prometheus.Collector.
+func (c *keySetAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- keySetAgeDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *keySetAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	syncers := make([]*keySyncer, 0, len(c.syncers))
+	for k := range c.syncers {
+		syncers = append(syncers, k)
+	}
+	c.mu.Unlock()
+
+	for _, k := range syncers {
+		age, ok := k.lastSuccessAge()
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(keySetAgeDesc, prometheus.GaugeValue, age.Seconds(), k.issuerURL)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/sigstore/fulcio/pkg/log"
+)
+
+// configMapKeyName is the key inside the ConfigMap's Data map that holds
+// the Fulcio config document, matching the convention used by the Helm
+// chart and the in-cluster deployment manifests.
+const configMapKeyName = "config.json"
+
+// ConfigProvider hands callers the current, immutable *FulcioConfig. It
+// exists so that FromContext can observe configuration updated by a
+// Watcher without requests needing to re-read the context on every change.
+type ConfigProvider interface {
+	// Config returns the currently active configuration. The returned
+	// value must never be mutated; a new *FulcioConfig is swapped in on
+	// every successful reload.
+	Config() *FulcioConfig
+}
+
+// staticProvider is a ConfigProvider that never changes, used when no
+// Watcher is in play (e.g. tests, or one-shot CLI invocations).
+type staticProvider struct {
+	cfg *FulcioConfig
+}
+
+func (s *staticProvider) Config() *FulcioConfig { return s.cfg }
+
+// NewStaticProvider wraps a fixed *FulcioConfig as a ConfigProvider.
+func NewStaticProvider(cfg *FulcioConfig) ConfigProvider {
+	return &staticProvider{cfg: cfg}
+}
+
+// Watcher supervises a config source -- either a file on disk or a
+// Kubernetes ConfigMap -- and atomically swaps in a newly parsed and
+// validated *FulcioConfig whenever the source changes. In-flight requests
+// that already hold a *FulcioConfig from Config() continue to see a
+// consistent, immutable snapshot.
+type Watcher struct {
+	current atomic.Pointer[FulcioConfig]
+
+	fileWatcher *fsnotify.Watcher
+	filePath    string
+
+	informerFactory informers.SharedInformerFactory
+	cancelInformer  context.CancelFunc
+}
+
+var _ ConfigProvider = (*Watcher)(nil)
+
+// Config implements ConfigProvider.
+func (w *Watcher) Config() *FulcioConfig {
+	return w.current.Load()
+}
+
+// NewFileWatcher creates a Watcher that loads configPath immediately and
+// then re-loads it on every fsnotify write/create/rename/remove event, and
+// whenever a periodic check finds the file's mtime has advanced.
+func NewFileWatcher(ctx context.Context, configPath string) (*Watcher, error) {
+	cfg, err := Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("initial load: %w", err)
+	}
+
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("fsnotify: %w", err)
+	}
+	if err := fw.Add(configPath); err != nil {
+		fw.Close()
+		return nil, fmt.Errorf("watch %s: %w", configPath, err)
+	}
+
+	w := &Watcher{fileWatcher: fw, filePath: configPath}
+	w.current.Store(cfg)
+
+	go w.watchFile(ctx)
+	return w, nil
+}
+
+// fileWatchFallbackInterval bounds how stale a config can get if fsnotify
+// misses an event entirely -- e.g. an editor or a Kubernetes projected
+// volume's "..data" symlink swap that doesn't generate the event pattern
+// we watch for on a given filesystem.
+const fileWatchFallbackInterval = 30 * time.Second
+
+func (w *Watcher) watchFile(ctx context.Context) {
+	lastModTime := w.statModTime()
+	ticker := time.NewTicker(fileWatchFallbackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.fileWatcher.Close()
+			return
+		case event, ok := <-w.fileWatcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+				continue
+			}
+			if event.Op&(fsnotify.Rename|fsnotify.Remove) != 0 {
+				// Atomic-save editors and Kubernetes projected-volume
+				// "..data" symlink swaps replace the watched inode,
+				// which silently drops fsnotify's watch. Re-arm it so
+				// future changes keep generating events.
+				_ = w.fileWatcher.Remove(w.filePath)
+				if err := w.fileWatcher.Add(w.filePath); err != nil {
+					log.Logger.Warnf("config watcher: failed to re-watch %s: %v", w.filePath, err)
+				}
+			}
+			w.reloadFile()
+			lastModTime = w.statModTime()
+		case err, ok := <-w.fileWatcher.Errors:
+			if !ok {
+				return
+			}
+			log.Logger.Warnf("config watcher: fsnotify error: %v", err)
+			configReloadFailureTotal.Inc()
+		case <-ticker.C:
+			if mt := w.statModTime(); mt.After(lastModTime) {
+				w.reloadFile()
+				lastModTime = mt
+			}
+		}
+	}
+}
+
+// statModTime returns configPath's current mtime, or the zero Time if it
+// can't be stat'd (e.g. mid-rename).
+func (w *Watcher) statModTime() time.Time {
+	info, err := os.Stat(w.filePath)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (w *Watcher) reloadFile() {
+	cfg, err := Load(w.filePath)
+	if err != nil {
+		log.Logger.Warnf("config watcher: keeping previous config, reload of %s failed: %v", w.filePath, err)
+		configReloadFailureTotal.Inc()
+		return
+	}
+	if old := w.current.Swap(cfg); old != nil {
+		old.Close()
+	}
+	configReloadSuccessTotal.Inc()
+	log.Logger.Infof("config watcher: reloaded config from %s", w.filePath)
+}
+
+// NewConfigMapWatcher creates a Watcher that loads the named ConfigMap
+// immediately via an in-cluster Kubernetes client and re-loads it whenever
+// the informer observes an add/update event.
+func NewConfigMapWatcher(ctx context.Context, client kubernetes.Interface, namespace, name string) (*Watcher, error) {
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get configmap %s/%s: %w", namespace, name, err)
+	}
+	cfg, err := fromConfigMap(cm)
+	if err != nil {
+		return nil, fmt.Errorf("initial load: %w", err)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}),
+	)
+
+	informerCtx, cancel := context.WithCancel(ctx)
+	w := &Watcher{informerFactory: factory, cancelInformer: cancel}
+	w.current.Store(cfg)
+
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { w.reloadConfigMap(obj) },
+		UpdateFunc: func(_, obj interface{}) { w.reloadConfigMap(obj) },
+	}); err != nil {
+		cancel()
+		return nil, fmt.Errorf("add event handler: %w", err)
+	}
+
+	factory.Start(informerCtx.Done())
+	factory.WaitForCacheSync(informerCtx.Done())
+	return w, nil
+}
+
+func (w *Watcher) reloadConfigMap(obj interface{}) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+	cfg, err := fromConfigMap(cm)
+	if err != nil {
+		log.Logger.Warnf("config watcher: keeping previous config, reload of configmap %s/%s failed: %v", cm.Namespace, cm.Name, err)
+		configReloadFailureTotal.Inc()
+		return
+	}
+	if old := w.current.Swap(cfg); old != nil {
+		old.Close()
+	}
+	configReloadSuccessTotal.Inc()
+	log.Logger.Infof("config watcher: reloaded config from configmap %s/%s", cm.Namespace, cm.Name)
+}
+
+func fromConfigMap(cm *corev1.ConfigMap) (*FulcioConfig, error) {
+	raw, ok := cm.Data[configMapKeyName]
+	if !ok {
+		return nil, fmt.Errorf("configmap %s/%s missing key %q", cm.Namespace, cm.Name, configMapKeyName)
+	}
+	return Read([]byte(raw))
+}
+
+// Close stops the underlying fsnotify watcher or Kubernetes informer, and
+// the key-sync goroutines of the currently active config.
+func (w *Watcher) Close() {
+	if w.fileWatcher != nil {
+		w.fileWatcher.Close()
+	}
+	if w.cancelInformer != nil {
+		w.cancelInformer()
+	}
+	if cfg := w.current.Load(); cfg != nil {
+		cfg.Close()
+	}
+}